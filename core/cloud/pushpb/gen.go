@@ -0,0 +1,11 @@
+// Copyright (C) 2017 Load Impact
+//
+// This file is part of k6 and is licensed under the GNU Affero General
+// Public License, version 3 or later. See the LICENSE file at the
+// repository root for details.
+
+// Package pushpb holds the generated client/server code for the streaming
+// PushService defined in pushservice.proto.
+package pushpb
+
+//go:generate protoc --go_out=plugins=grpc:. pushservice.proto
@@ -0,0 +1,277 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/loadimpact/k6/core/cloud"
+	"github.com/loadimpact/k6/stats"
+	log "github.com/sirupsen/logrus"
+)
+
+// AggregationConfig controls the (optional) aggregation layer that
+// collapses many stats.Sample observations into one AggregatedSample per
+// metric/tag-set/time bucket before they are handed to the sinks.
+type AggregationConfig struct {
+	Aggregation           bool          `mapstructure:"aggregation"`
+	AggregationPeriod     time.Duration `mapstructure:"aggregation_period"`
+	AggregationMinSamples int           `mapstructure:"aggregation_min_samples"`
+}
+
+func defaultAggregationConfig() AggregationConfig {
+	return AggregationConfig{
+		Aggregation:           false,
+		AggregationPeriod:     1 * time.Second,
+		AggregationMinSamples: 2,
+	}
+}
+
+// AggregatedSample is the result of folding every stats.Sample observed for
+// a metric/tag-set within a single time bucket into one value: the latest
+// value for Gauges, the sum for Counters, and count/min/max/sum plus a few
+// percentiles for Trends.
+type AggregatedSample struct {
+	Metric string
+	Tags   map[string]string
+	Time   time.Time
+
+	Count int
+	Sum   float64
+	Min   float64
+	Max   float64
+	Last  float64
+
+	// Percentiles holds the Trend percentiles requested by trendPercentiles,
+	// keyed by label (e.g. "p50", "p95").
+	Percentiles map[string]float64
+}
+
+// toCloudSample packs the aggregate into the existing cloud.Sample wire
+// format. cloud.SampleData has no fields for percentiles or sample counts,
+// so - rather than waiting on a server-side schema change - they are packed
+// into reserved, underscore-prefixed tags that the cloud ingest endpoint
+// already ignores unless it recognizes them.
+func (a *AggregatedSample) toCloudSample(metricType stats.MetricType) *cloud.Sample {
+	tags := make(map[string]string, len(a.Tags)+len(a.Percentiles)+3)
+	for k, v := range a.Tags {
+		tags[k] = v
+	}
+
+	value := a.Sum
+	switch metricType {
+	case stats.Gauge:
+		value = a.Last
+	case stats.Rate:
+		value = a.Sum / float64(a.Count)
+	case stats.Trend:
+		tags["_count"] = strconv.Itoa(a.Count)
+		tags["_min"] = formatFloat(a.Min)
+		tags["_max"] = formatFloat(a.Max)
+		tags["_sum"] = formatFloat(a.Sum)
+		for label, v := range a.Percentiles {
+			tags["_"+label] = formatFloat(v)
+		}
+		value = a.Sum / float64(a.Count)
+	}
+
+	return &cloud.Sample{
+		Type:   "Aggregate",
+		Metric: a.Metric,
+		Data: cloud.SampleData{
+			Type:  metricType,
+			Time:  a.Time,
+			Value: value,
+			Tags:  tags,
+		},
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+var trendPercentiles = []float64{50, 90, 95, 99}
+
+type bucketKey struct {
+	metric string
+	tags   string
+	bucket int64
+}
+
+type bucket struct {
+	metric     string
+	metricType stats.MetricType
+	tags       map[string]string
+	time       time.Time
+	count      int
+	sum        float64
+	min        float64
+	max        float64
+	last       float64
+	values     []float64 // Every observation in this bucket, used for Trend percentiles and for passing small buckets through on flush.
+}
+
+// aggregator buckets incoming samples by (metric name, tag-set, time
+// bucket) so pushMetrics can emit one AggregatedSample per bucket instead
+// of one cloud.Sample per observation.
+type aggregator struct {
+	period     time.Duration
+	minSamples int
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucket
+}
+
+func newAggregator(cfg AggregationConfig) *aggregator {
+	period := cfg.AggregationPeriod
+	if period <= 0 {
+		log.WithFields(log.Fields{
+			"configured": cfg.AggregationPeriod,
+			"used":       defaultAggregationConfig().AggregationPeriod,
+		}).Warn("aggregation_period must be positive, falling back to the default")
+		period = defaultAggregationConfig().AggregationPeriod
+	}
+
+	return &aggregator{
+		period:     period,
+		minSamples: cfg.AggregationMinSamples,
+		buckets:    make(map[bucketKey]*bucket),
+	}
+}
+
+func (a *aggregator) Add(samples []stats.Sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, samp := range samples {
+		key := bucketKey{
+			metric: samp.Metric.Name,
+			tags:   tagKey(samp.Tags),
+			bucket: samp.Time.UnixNano() / int64(a.period),
+		}
+
+		b, ok := a.buckets[key]
+		if !ok {
+			b = &bucket{
+				metric:     samp.Metric.Name,
+				metricType: samp.Metric.Type,
+				tags:       samp.Tags,
+				time:       samp.Time.Truncate(a.period),
+				min:        samp.Value,
+				max:        samp.Value,
+			}
+			a.buckets[key] = b
+		}
+
+		b.count++
+		b.sum += samp.Value
+		b.last = samp.Value
+		if samp.Value < b.min {
+			b.min = samp.Value
+		}
+		if samp.Value > b.max {
+			b.max = samp.Value
+		}
+		b.values = append(b.values, samp.Value)
+	}
+}
+
+// Flush returns every bucket collected so far as cloud.Sample and resets
+// the aggregator. This includes a bucket whose time period has not ended
+// yet; observations added to that period after the flush are emitted by a
+// later Flush as a separate sample with the same time. Buckets with fewer
+// than minSamples observations are passed through unaggregated instead, as
+// one "Point" sample per observation, so a handful of rare metrics (e.g. a
+// single setup() call) still retain full fidelity.
+func (a *aggregator) Flush() (aggregated []*cloud.Sample) {
+	a.mu.Lock()
+	buckets := a.buckets
+	a.buckets = make(map[bucketKey]*bucket)
+	a.mu.Unlock()
+
+	for _, b := range buckets {
+		if b.count < a.minSamples {
+			for _, v := range b.values {
+				aggregated = append(aggregated, &cloud.Sample{
+					Type:   "Point",
+					Metric: b.metric,
+					Data: cloud.SampleData{
+						Type:  b.metricType,
+						Time:  b.time,
+						Value: v,
+						Tags:  b.tags,
+					},
+				})
+			}
+			continue
+		}
+
+		agg := &AggregatedSample{
+			Metric: b.metric,
+			Tags:   b.tags,
+			Time:   b.time,
+			Count:  b.count,
+			Sum:    b.sum,
+			Min:    b.min,
+			Max:    b.max,
+			Last:   b.last,
+		}
+		if b.metricType == stats.Trend {
+			agg.Percentiles = percentilesOf(b.values)
+		}
+		aggregated = append(aggregated, agg.toCloudSample(b.metricType))
+	}
+	return aggregated
+}
+
+func percentilesOf(values []float64) map[string]float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	out := make(map[string]float64, len(trendPercentiles))
+	for _, p := range trendPercentiles {
+		idx := int(p / 100 * float64(len(sorted)-1))
+		out[fmt.Sprintf("p%g", p)] = sorted[idx]
+	}
+	return out
+}
+
+func tagKey(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	key := ""
+	for _, k := range keys {
+		key += k + "=" + tags[k] + "\x00"
+	}
+	return key
+}
@@ -0,0 +1,145 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/stats"
+)
+
+func TestNewAggregatorClampsNonPositivePeriod(t *testing.T) {
+	agg := newAggregator(AggregationConfig{AggregationPeriod: 0, AggregationMinSamples: 2})
+	if agg.period != defaultAggregationConfig().AggregationPeriod {
+		t.Fatalf("expected period to fall back to the default, got %v", agg.period)
+	}
+
+	agg = newAggregator(AggregationConfig{AggregationPeriod: -time.Second, AggregationMinSamples: 2})
+	if agg.period != defaultAggregationConfig().AggregationPeriod {
+		t.Fatalf("expected negative period to fall back to the default, got %v", agg.period)
+	}
+}
+
+func trendMetric() *stats.Metric {
+	return &stats.Metric{Name: "my_trend", Type: stats.Trend}
+}
+
+func TestAggregatorFlushAboveMinSamplesAggregates(t *testing.T) {
+	agg := newAggregator(AggregationConfig{AggregationPeriod: time.Second, AggregationMinSamples: 2})
+	metric := trendMetric()
+	now := time.Unix(0, 0)
+
+	agg.Add([]stats.Sample{
+		{Metric: metric, Time: now, Value: 1},
+		{Metric: metric, Time: now, Value: 3},
+	})
+
+	out := agg.Flush()
+	if len(out) != 1 {
+		t.Fatalf("expected 1 aggregated sample, got %d", len(out))
+	}
+	if out[0].Type != "Aggregate" {
+		t.Fatalf("expected an Aggregate sample, got %q", out[0].Type)
+	}
+	if out[0].Data.Tags["_count"] != "2" {
+		t.Fatalf("expected _count tag of 2, got %q", out[0].Data.Tags["_count"])
+	}
+}
+
+func TestAggregatorFlushAveragesRateMetrics(t *testing.T) {
+	agg := newAggregator(AggregationConfig{AggregationPeriod: time.Second, AggregationMinSamples: 2})
+	metric := &stats.Metric{Name: "http_req_failed", Type: stats.Rate}
+	now := time.Unix(0, 0)
+
+	agg.Add([]stats.Sample{
+		{Metric: metric, Time: now, Value: 1},
+		{Metric: metric, Time: now, Value: 0},
+		{Metric: metric, Time: now, Value: 0},
+		{Metric: metric, Time: now, Value: 0},
+	})
+
+	out := agg.Flush()
+	if len(out) != 1 {
+		t.Fatalf("expected 1 aggregated sample, got %d", len(out))
+	}
+	if out[0].Data.Value != 0.25 {
+		t.Fatalf("expected rate of 0.25, got %v", out[0].Data.Value)
+	}
+}
+
+func TestAggregatorFlushBelowMinSamplesPassesThrough(t *testing.T) {
+	agg := newAggregator(AggregationConfig{AggregationPeriod: time.Second, AggregationMinSamples: 2})
+	metric := trendMetric()
+	now := time.Unix(0, 0)
+
+	agg.Add([]stats.Sample{{Metric: metric, Time: now, Value: 5}})
+
+	out := agg.Flush()
+	if len(out) != 1 {
+		t.Fatalf("expected 1 passthrough sample, got %d", len(out))
+	}
+	if out[0].Type != "Point" {
+		t.Fatalf("expected a Point sample, got %q", out[0].Type)
+	}
+	if out[0].Data.Value != 5 {
+		t.Fatalf("expected value 5, got %v", out[0].Data.Value)
+	}
+}
+
+func TestAggregatorFlushResetsBuckets(t *testing.T) {
+	agg := newAggregator(AggregationConfig{AggregationPeriod: time.Second, AggregationMinSamples: 2})
+	metric := trendMetric()
+	now := time.Unix(0, 0)
+
+	agg.Add([]stats.Sample{
+		{Metric: metric, Time: now, Value: 1},
+		{Metric: metric, Time: now, Value: 2},
+	})
+	agg.Flush()
+
+	if out := agg.Flush(); len(out) != 0 {
+		t.Fatalf("expected no samples on a second flush with no new data, got %d", len(out))
+	}
+}
+
+func TestPercentilesOf(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	percentiles := percentilesOf(values)
+
+	if percentiles["p50"] != 5 {
+		t.Errorf("expected p50 to be 5, got %v", percentiles["p50"])
+	}
+	if percentiles["p99"] != 9 {
+		t.Errorf("expected p99 to be 9, got %v", percentiles["p99"])
+	}
+}
+
+func TestTagKeyIsOrderIndependent(t *testing.T) {
+	a := tagKey(map[string]string{"b": "2", "a": "1"})
+	b := tagKey(map[string]string{"a": "1", "b": "2"})
+	if a != b {
+		t.Fatalf("expected tagKey to be independent of map iteration order, got %q vs %q", a, b)
+	}
+	if tagKey(nil) != "" {
+		t.Fatalf("expected empty tags to produce an empty key")
+	}
+}
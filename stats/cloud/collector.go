@@ -49,6 +49,15 @@ type Collector struct {
 	duration   int64
 	thresholds map[string][]*stats.Threshold
 	client     *cloud.Client
+	retry      RetryConfig
+	transport  TransportConfig
+	sinks      []Sink
+	agg        *aggregator
+
+	spoolConfig   SpoolConfig
+	spoolEnabled  bool
+	spool         *spool // holds batches a push sink itself failed to send; drained back to that sink alone
+	overflowSpool *spool // holds sampleBuffer overflow that no sink has seen yet; drained back into sampleBuffer for a full re-fanout
 
 	sampleBuffer []*cloud.Sample
 	sampleMu     sync.Mutex
@@ -59,11 +68,33 @@ func New(fname string, src *lib.SourceData, opts lib.Options, version string) (*
 	token := os.Getenv("K6CLOUD_TOKEN")
 
 	var extConfig cloud.LoadImpactConfig
+	retry := defaultRetryConfig()
+	var sinksConfig SinksConfig
+	aggConfig := defaultAggregationConfig()
+	spoolConfig := defaultSpoolConfig()
+	spoolEnabled := false
+	var transportConfig TransportConfig
 	if val, ok := opts.External["loadimpact"]; ok {
 		err := mapstructure.Decode(val, &extConfig)
 		if err != nil {
 			log.Warn("Malformed loadimpact settings in script options")
 		}
+		if err := mapstructure.Decode(val, &retry); err != nil {
+			log.Warn("Malformed loadimpact retry settings in script options")
+		}
+		if err := mapstructure.Decode(val, &sinksConfig); err != nil {
+			log.Warn("Malformed loadimpact sinks settings in script options")
+		}
+		if err := mapstructure.Decode(val, &aggConfig); err != nil {
+			log.Warn("Malformed loadimpact aggregation settings in script options")
+		}
+		if err := mapstructure.Decode(val, &spoolConfig); err != nil {
+			log.Warn("Malformed loadimpact spool settings in script options")
+		}
+		spoolEnabled = hasExternalKey(val, "spool_dir") || hasExternalKey(val, "spool_max_bytes")
+		if err := mapstructure.Decode(val, &transportConfig); err != nil {
+			log.Warn("Malformed loadimpact transport settings in script options")
+		}
 	}
 
 	thresholds := make(map[string][]*stats.Threshold)
@@ -79,13 +110,37 @@ func New(fname string, src *lib.SourceData, opts lib.Options, version string) (*
 		duration = int64(time.Duration(opts.Duration.Duration).Seconds())
 	}
 
-	return &Collector{
+	c := &Collector{
 		name:       extConfig.GetName(src),
 		project_id: extConfig.GetProjectId(),
 		thresholds: thresholds,
 		client:     cloud.NewClient(token, "", version),
 		duration:   duration,
-	}, nil
+		retry:      retry,
+		transport:  transportConfig,
+	}
+	// The cloud sink preserves the collector's original, single-destination
+	// behavior; any sinks configured under loadimpact.sinks are additional.
+	c.sinks = append([]Sink{&cloudSink{collector: c}}, buildSinks(sinksConfig)...)
+	if aggConfig.Aggregation {
+		c.agg = newAggregator(aggConfig)
+	}
+	c.spoolConfig = spoolConfig
+	c.spoolEnabled = spoolEnabled
+
+	return c, nil
+}
+
+// hasExternalKey reports whether the raw "loadimpact" options value
+// explicitly sets key, so a feature can be opted into only when the user
+// configures it rather than whenever its decoded zero value looks set.
+func hasExternalKey(val interface{}, key string) bool {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = m[key]
+	return ok
 }
 
 func (c *Collector) Init() error {
@@ -104,7 +159,17 @@ func (c *Collector) Init() error {
 		ProjectID:  c.project_id,
 	}
 
-	response, err := c.client.CreateTestRun(testRun)
+	var response *cloud.CreateTestRunResponse
+	err := withRetry(c.retry.Attempts, c.retry.BaseDelay, c.retry.MaxDelay, func() error {
+		return withTimeout(c.retry.CallTimeout, func() error {
+			r, err := c.client.CreateTestRun(testRun)
+			if err != nil {
+				return err
+			}
+			response = r
+			return nil
+		})
+	})
 
 	if err != nil {
 		c.initErr = err
@@ -115,6 +180,26 @@ func (c *Collector) Init() error {
 	}
 	c.referenceID = response.ReferenceID
 
+	// c.sinks[0] is always the cloud-facing sink; now that the
+	// referenceID is known, pick gRPC over HTTP if it's available and
+	// not disabled.
+	c.sinks[0] = pushSink(c, c.transport.GRPCAddr, c.transport)
+
+	if c.spoolEnabled {
+		sp, err := newSpool(c.spoolConfig, c.referenceID+"/push")
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("Failed to set up on-disk spool, falling back to in-memory buffering only")
+		} else {
+			c.spool = sp
+		}
+		overflow, err := newSpool(c.spoolConfig, c.referenceID+"/overflow")
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("Failed to set up on-disk overflow spool, falling back to in-memory buffering only")
+		} else {
+			c.overflowSpool = overflow
+		}
+	}
+
 	log.WithFields(log.Fields{
 		"name":        c.name,
 		"projectId":   c.project_id,
@@ -149,7 +234,13 @@ func (c *Collector) Run(ctx context.Context) {
 			c.pushMetrics()
 		case <-ctx.Done():
 			c.pushMetrics()
+			c.blockUntilSpoolDrained(c.spoolConfig.SpoolDrainTimeout)
 			c.testFinished()
+			for _, sink := range c.sinks {
+				if err := sink.Close(); err != nil {
+					log.WithFields(log.Fields{"error": err}).Warn("Failed to close metric sink")
+				}
+			}
 			return
 		}
 	}
@@ -164,6 +255,11 @@ func (c *Collector) Collect(samples []stats.Sample) {
 		return
 	}
 
+	if c.agg != nil {
+		c.agg.Add(samples)
+		return
+	}
+
 	var cloudSamples []*cloud.Sample
 	for _, samp := range samples {
 		sampleJSON := &cloud.Sample{
@@ -184,9 +280,58 @@ func (c *Collector) Collect(samples []stats.Sample) {
 		c.sampleBuffer = append(c.sampleBuffer, cloudSamples...)
 		c.sampleMu.Unlock()
 	}
+
+	c.spoolOverflow()
+}
+
+// approxSampleBytes is a rough, constant estimate of a marshaled
+// cloud.Sample's size in bytes. Computing the real JSON size on every
+// Collect call would mean marshaling the whole buffer just to decide
+// whether to spool it, so this trades precision for a watermark check
+// that's cheap enough to run on every tick.
+const approxSampleBytes = 200
+
+// spoolOverflow moves the in-memory buffer to disk once it crosses the
+// configured memory watermark, even while pushes are still succeeding -
+// this is what keeps Collect() from growing sampleBuffer without bound
+// when VUs produce samples faster than the push ticker drains them.
+func (c *Collector) spoolOverflow() {
+	if c.overflowSpool == nil || c.spoolConfig.SpoolMaxBytes <= 0 {
+		return
+	}
+
+	c.sampleMu.Lock()
+	if int64(len(c.sampleBuffer))*approxSampleBytes <= c.spoolConfig.SpoolMaxBytes {
+		c.sampleMu.Unlock()
+		return
+	}
+	overflow := c.sampleBuffer
+	c.sampleBuffer = nil
+	c.sampleMu.Unlock()
+
+	if err := c.overflowSpool.Write(overflow); err != nil {
+		log.WithFields(log.Fields{"error": err}).Warn("Failed to spool buffer past the memory watermark, keeping it in memory")
+		c.sampleMu.Lock()
+		c.sampleBuffer = append(overflow, c.sampleBuffer...)
+		c.sampleMu.Unlock()
+	}
 }
 
+// pushMetrics drains the sample buffer and fans it out to every configured
+// sink in parallel. A sink failing to write (e.g. an unreachable InfluxDB
+// instance) is logged and does not affect the other sinks - in particular
+// it never blocks the cloud upload.
 func (c *Collector) pushMetrics() {
+	c.drainSpool()
+
+	if c.agg != nil {
+		if aggregated := c.agg.Flush(); len(aggregated) > 0 {
+			c.sampleMu.Lock()
+			c.sampleBuffer = append(c.sampleBuffer, aggregated...)
+			c.sampleMu.Unlock()
+		}
+	}
+
 	c.sampleMu.Lock()
 	if len(c.sampleBuffer) == 0 {
 		c.sampleMu.Unlock()
@@ -198,14 +343,110 @@ func (c *Collector) pushMetrics() {
 
 	log.WithFields(log.Fields{
 		"samples": len(buffer),
-	}).Debug("Pushing metrics to cloud")
+		"sinks":   len(c.sinks),
+	}).Debug("Pushing metrics to sinks")
+
+	var wg sync.WaitGroup
+	for _, sink := range c.sinks {
+		wg.Add(1)
+		go func(sink Sink) {
+			defer wg.Done()
+			if err := sink.Write(buffer); err != nil {
+				log.WithFields(log.Fields{
+					"error": err,
+				}).Warn("Sink failed to write metrics")
+			}
+		}(sink)
+	}
+	wg.Wait()
+}
 
-	err := c.client.PushMetric(c.referenceID, buffer)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Warn("Failed to send metrics to cloud")
+// drainSpool drains both on-disk spools used by the collector. Each holds
+// data that reached a different stage of the pipeline, so each is drained
+// back to a different destination.
+func (c *Collector) drainSpool() {
+	c.drainOverflowSpool()
+	c.drainRequeueSpool()
+}
+
+// drainOverflowSpool pulls batches written by spoolOverflow - samples that
+// had crossed the memory watermark before any sink saw them - back into
+// the shared sample buffer, so pushMetrics fans them out to every
+// configured sink exactly like freshly collected samples, rather than
+// only the push sink.
+func (c *Collector) drainOverflowSpool() {
+	if c.overflowSpool == nil {
+		return
+	}
+	for {
+		samples, err := c.overflowSpool.Drain()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("Failed to drain overflow spool")
+			return
+		}
+		if samples == nil {
+			return
+		}
+		c.sampleMu.Lock()
+		c.sampleBuffer = append(c.sampleBuffer, samples...)
+		c.sampleMu.Unlock()
+	}
+}
+
+// drainRequeueSpool pulls every batch back to the sink that originally
+// failed to send it (c.sinks[0], the cloud or gRPC push sink - this spool
+// only ever holds data written by that sink's own requeue path), so it
+// rides along with that sink's next write instead of being redelivered to
+// every sink in the fan-out.
+func (c *Collector) drainRequeueSpool() {
+	if c.spool == nil {
+		return
 	}
+	receiver, ok := c.sinks[0].(pendingReceiver)
+	if !ok {
+		return
+	}
+	for {
+		samples, err := c.spool.Drain()
+		if err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("Failed to drain spooled samples")
+			return
+		}
+		if samples == nil {
+			return
+		}
+		receiver.receivePending(samples)
+	}
+}
+
+// blockUntilSpoolDrained retries pushes until the spool is empty or
+// timeout elapses, so testFinished is never sent to the cloud before the
+// samples backing it have arrived.
+func (c *Collector) blockUntilSpoolDrained(timeout time.Duration) {
+	if c.spool == nil && c.overflowSpool == nil {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	for !c.spoolsEmpty() {
+		if time.Now().After(deadline) {
+			log.Warn("Timed out waiting for the spool to drain before sending test finished")
+			return
+		}
+		c.pushMetrics()
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// spoolsEmpty reports whether both on-disk spools have been fully drained.
+func (c *Collector) spoolsEmpty() bool {
+	if c.spool != nil && !c.spool.Empty() {
+		return false
+	}
+	if c.overflowSpool != nil && !c.overflowSpool.Empty() {
+		return false
+	}
+	return true
 }
 
 func (c *Collector) testFinished() {
@@ -230,7 +471,11 @@ func (c *Collector) testFinished() {
 		"tainted": testTainted,
 	}).Debug("Sending test finished")
 
-	err := c.client.TestFinished(c.referenceID, thresholdResults, testTainted)
+	err := withRetry(c.retry.Attempts, c.retry.BaseDelay, c.retry.MaxDelay, func() error {
+		return withTimeout(c.retry.CallTimeout, func() error {
+			return c.client.TestFinished(c.referenceID, thresholdResults, testTainted)
+		})
+	})
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error": err,
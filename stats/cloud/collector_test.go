@@ -0,0 +1,195 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loadimpact/k6/core/cloud"
+	"github.com/loadimpact/k6/stats"
+)
+
+// fakeSink records every batch it's given and optionally fails its first N
+// writes, so tests can exercise fan-out and per-sink isolation without a
+// real InfluxDB/Prometheus/cloud backend.
+type fakeSink struct {
+	failWrites int
+	writes     [][]*cloud.Sample
+}
+
+func (f *fakeSink) Write(samples []*cloud.Sample) error {
+	f.writes = append(f.writes, samples)
+	if f.failWrites > 0 {
+		f.failWrites--
+		return errors.New("sink unavailable")
+	}
+	return nil
+}
+
+func (f *fakeSink) Flush() error { return nil }
+func (f *fakeSink) Close() error { return nil }
+
+// fakePushSink stands in for cloudSink/grpcSink in tests: it is the sink at
+// c.sinks[0] and implements pendingReceiver, without needing a real client
+// or transport behind it.
+type fakePushSink struct {
+	fakeSink
+	received [][]*cloud.Sample
+}
+
+func (f *fakePushSink) receivePending(samples []*cloud.Sample) {
+	f.received = append(f.received, samples)
+}
+
+func newTestCollector(sinks ...Sink) *Collector {
+	return &Collector{
+		referenceID: "ref1",
+		retry:       defaultRetryConfig(),
+		sinks:       sinks,
+	}
+}
+
+func TestCollectSpoolsOverflowAndFansOutToAllSinksOnDrain(t *testing.T) {
+	sp, err := newSpool(SpoolConfig{SpoolDir: t.TempDir()}, "ref1")
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+
+	cloudSink, influx := &fakeSink{}, &fakeSink{}
+	c := newTestCollector(cloudSink, influx)
+	c.overflowSpool = sp
+	c.spoolConfig = SpoolConfig{SpoolMaxBytes: 1}
+
+	metric := &stats.Metric{Name: "my_metric", Type: stats.Trend}
+	c.Collect([]stats.Sample{{Metric: metric, Time: time.Unix(0, 0), Value: 1}})
+
+	if sp.Empty() {
+		t.Fatal("expected the overflowing buffer to have been spooled to disk")
+	}
+	if len(c.sampleBuffer) != 0 {
+		t.Fatalf("expected sampleBuffer to be emptied by the spool, got %d samples", len(c.sampleBuffer))
+	}
+
+	c.pushMetrics()
+
+	if !sp.Empty() {
+		t.Fatal("expected pushMetrics to drain the overflow spool")
+	}
+	for name, s := range map[string]*fakeSink{"cloud": cloudSink, "influx": influx} {
+		if len(s.writes) != 1 || len(s.writes[0]) != 1 {
+			t.Fatalf("expected %s sink to receive the spooled batch, got %v", name, s.writes)
+		}
+	}
+}
+
+func TestPushMetricsRequeueDrainOnlyReachesPushSink(t *testing.T) {
+	sp, err := newSpool(SpoolConfig{SpoolDir: t.TempDir()}, "ref1")
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+
+	push := &fakePushSink{}
+	influx := &fakeSink{}
+	c := newTestCollector(push, influx)
+	c.spool = sp
+
+	// Simulate a prior tick where the push sink alone failed to deliver and
+	// requeued its batch to the on-disk spool.
+	if err := sp.Write(sampleBatch(1, 2)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	c.sampleBuffer = append(c.sampleBuffer, sampleBatch(3)...)
+	c.pushMetrics()
+
+	if !sp.Empty() {
+		t.Fatal("expected pushMetrics to drain the requeue spool")
+	}
+	if len(push.received) != 1 || len(push.received[0]) != 2 {
+		t.Fatalf("expected the push sink to receive the requeued batch via receivePending, got %v", push.received)
+	}
+	if len(influx.writes) != 1 || len(influx.writes[0]) != 1 {
+		t.Fatalf("expected the secondary sink to see only the fresh sample, not the requeued batch, got %v", influx.writes)
+	}
+	if len(push.writes) != 1 || len(push.writes[0]) != 1 {
+		t.Fatalf("expected the push sink's Write to only see the fresh sample from sampleBuffer, got %v", push.writes)
+	}
+}
+
+func TestPushMetricsFlushesAggregatorBeforeFanningOut(t *testing.T) {
+	cloudSink, influx := &fakeSink{}, &fakeSink{}
+	c := newTestCollector(cloudSink, influx)
+	c.agg = newAggregator(AggregationConfig{AggregationPeriod: time.Second, AggregationMinSamples: 1})
+
+	metric := &stats.Metric{Name: "my_counter", Type: stats.Counter}
+	c.Collect([]stats.Sample{
+		{Metric: metric, Time: time.Unix(0, 0), Value: 1},
+		{Metric: metric, Time: time.Unix(0, 0), Value: 2},
+	})
+
+	c.pushMetrics()
+
+	for name, s := range map[string]*fakeSink{"cloud": cloudSink, "influx": influx} {
+		if len(s.writes) != 1 || len(s.writes[0]) != 1 {
+			t.Fatalf("expected %s sink to receive 1 aggregated sample, got %v", name, s.writes)
+		}
+		if s.writes[0][0].Data.Value != 3 {
+			t.Fatalf("expected the aggregated counter sum of 3, got %v", s.writes[0][0].Data.Value)
+		}
+	}
+}
+
+func TestPushMetricsIsolatesSinkFailures(t *testing.T) {
+	good := &fakeSink{}
+	bad := &fakeSink{failWrites: 1}
+	c := newTestCollector(good, bad)
+	c.sampleBuffer = sampleBatch(1)
+
+	c.pushMetrics()
+
+	if len(good.writes) != 1 {
+		t.Fatalf("expected the healthy sink to still receive the batch, got %d writes", len(good.writes))
+	}
+	if len(bad.writes) != 1 {
+		t.Fatalf("expected the failing sink to still have been attempted, got %d writes", len(bad.writes))
+	}
+}
+
+func TestBlockUntilSpoolDrainedWaitsForOverflowSpool(t *testing.T) {
+	sp, err := newSpool(SpoolConfig{SpoolDir: t.TempDir()}, "ref1")
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+
+	c := newTestCollector(&fakeSink{})
+	c.overflowSpool = sp
+	if err := sp.Write(sampleBatch(1)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	c.blockUntilSpoolDrained(time.Second)
+
+	if !c.spoolsEmpty() {
+		t.Fatal("expected blockUntilSpoolDrained to drain the overflow spool before returning")
+	}
+}
@@ -0,0 +1,367 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/loadimpact/k6/core/cloud"
+	"github.com/loadimpact/k6/core/cloud/pushpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TransportConfig selects and tunes the transport used to push samples to
+// the cloud.
+type TransportConfig struct {
+	// Transport forces a specific transport. "" (the default) tries gRPC
+	// first and falls back to HTTP if no gRPC connection to GRPCAddr can
+	// be established; "http" always uses the batched HTTP push.
+	Transport string `mapstructure:"transport"`
+	// GRPCAddr is the host:port of the streaming PushService. Left empty,
+	// the gRPC transport is never attempted and the collector stays on
+	// the HTTP push.
+	GRPCAddr string `mapstructure:"grpc_addr"`
+}
+
+// grpcTransport streams samples to the cloud over a single long-lived
+// bidirectional gRPC stream instead of issuing one HTTP request per tick.
+// Samples are only dropped from the caller's retransmit responsibility
+// once the server has Acked them, and a broken stream is transparently
+// re-established with backoff.
+type grpcTransport struct {
+	addr        string
+	token       string
+	referenceID string
+
+	mu          sync.Mutex
+	conn        *grpc.ClientConn
+	stream      pushpb.PushService_StreamClient
+	nextSeq     uint64
+	unacked     map[uint64][]*cloud.Sample // sent, not yet Acked - replayed after a reconnect
+	maxInFlight int
+}
+
+func newGRPCTransport(addr, token, referenceID string) *grpcTransport {
+	return &grpcTransport{
+		addr:        addr,
+		token:       token,
+		referenceID: referenceID,
+		unacked:     make(map[uint64][]*cloud.Sample),
+		maxInFlight: 64,
+	}
+}
+
+// supportsGRPC reports whether a TLS gRPC connection to addr can be
+// established within dial's timeout; the collector falls back to HTTP when
+// it can't. It does not check that the server implements PushService.
+func supportsGRPC(addr string) bool {
+	conn, err := dial(addr)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	return true
+}
+
+func dial(addr string) (*grpc.ClientConn, error) {
+	return grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(nil)), grpc.WithBlock(), grpc.WithTimeout(5*time.Second))
+}
+
+func (t *grpcTransport) connect() error {
+	conn, err := dial(t.addr)
+	if err != nil {
+		return err
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "token "+t.token)
+	stream, err := pushpb.NewPushServiceClient(conn).Stream(ctx)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	t.mu.Lock()
+	oldConn := t.conn
+	t.conn = conn
+	t.stream = stream
+	// Snapshot the entries to replay - t.unacked is mutated concurrently
+	// by readAcks (started below) as soon as the first Ack comes back, so
+	// ranging over t.unacked itself after unlocking would race with that.
+	pending := make(map[uint64][]*cloud.Sample, len(t.unacked))
+	for seq, samples := range t.unacked {
+		pending[seq] = samples
+	}
+	t.mu.Unlock()
+
+	// The previous connection (if any) is now superseded - close it so a
+	// test that reconnects repeatedly doesn't leak one socket and its
+	// background goroutines per reconnect.
+	if oldConn != nil {
+		if err := oldConn.Close(); err != nil {
+			log.WithFields(log.Fields{"error": err}).Warn("Failed to close previous gRPC connection on reconnect")
+		}
+	}
+
+	go t.readAcks(stream)
+
+	// Replay anything sent but not yet Acked by the previous stream.
+	for seq, samples := range pending {
+		if err := t.sendOnStream(stream, seq, samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *grpcTransport) readAcks(stream pushpb.PushService_StreamClient) {
+	for {
+		ack, err := stream.Recv()
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		for seq := range t.unacked {
+			if seq <= ack.UpToSeq {
+				delete(t.unacked, seq)
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// untrackedWriteError wraps a Write failure that happened before the batch
+// was ever recorded in t.unacked (backpressure, or a failed reconnect). It
+// tells the caller that nothing else is holding onto this batch for replay,
+// so the caller must requeue it itself or lose it. A plain error from Write
+// means the batch already made it into t.unacked and will be replayed by
+// connect() once the stream is re-established - requeuing it again on top
+// of that would send it twice under two different sequence numbers.
+type untrackedWriteError struct {
+	err error
+}
+
+func (e *untrackedWriteError) Error() string { return e.err.Error() }
+func (e *untrackedWriteError) Unwrap() error { return e.err }
+
+// Write sends samples on the open stream, reconnecting with backoff if the
+// stream has broken. While maxInFlight or more batches are awaiting
+// acknowledgement it does not block: it refuses the batch at once with an
+// untrackedWriteError (simple flow control).
+func (t *grpcTransport) Write(samples []*cloud.Sample) error {
+	t.mu.Lock()
+	stream := t.stream
+	inFlight := len(t.unacked)
+	t.mu.Unlock()
+
+	if inFlight >= t.maxInFlight {
+		return &untrackedWriteError{fmt.Errorf("grpc transport backpressure: %d batches awaiting ack", inFlight)}
+	}
+
+	if stream == nil {
+		if err := t.reconnectWithBackoff(); err != nil {
+			return &untrackedWriteError{err}
+		}
+		t.mu.Lock()
+		stream = t.stream
+		t.mu.Unlock()
+	}
+
+	t.mu.Lock()
+	seq := t.nextSeq
+	t.nextSeq++
+	t.unacked[seq] = samples
+	t.mu.Unlock()
+
+	if err := t.sendOnStream(stream, seq, samples); err != nil {
+		t.mu.Lock()
+		t.stream = nil
+		t.mu.Unlock()
+		// samples stays in t.unacked and will be replayed on the next
+		// successful connect() - not an untrackedWriteError.
+		return err
+	}
+	return nil
+}
+
+func (t *grpcTransport) sendOnStream(stream pushpb.PushService_StreamClient, seq uint64, samples []*cloud.Sample) error {
+	for _, samp := range samples {
+		pbSamp := &pushpb.Sample{
+			Seq:          seq,
+			ReferenceId:  t.referenceID,
+			Type:         samp.Type,
+			Metric:       samp.Metric,
+			MetricType:   samp.Data.Type.String(),
+			TimeUnixNano: samp.Data.Time.UnixNano(),
+			Value:        samp.Data.Value,
+			Tags:         samp.Data.Tags,
+		}
+		if err := stream.Send(pbSamp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *grpcTransport) reconnectWithBackoff() error {
+	delay := 500 * time.Millisecond
+	const maxDelay = 30 * time.Second
+	for attempt := 0; attempt < 10; attempt++ {
+		if err := t.connect(); err == nil {
+			return nil
+		}
+		jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		time.Sleep(delay + jitter)
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	return fmt.Errorf("gRPC transport: failed to reconnect to %s", t.addr)
+}
+
+// TakeUnacked removes and returns every batch still awaiting an Ack,
+// flattened into a single slice. It hands ownership of replaying them to
+// the caller (grpcSink, on a tracked Write failure) instead of this
+// transport's own reconnect-replay, so they can be spooled to disk and
+// survive a process crash or exit instead of only living in this map.
+func (t *grpcTransport) TakeUnacked() []*cloud.Sample {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var all []*cloud.Sample
+	for seq, samples := range t.unacked {
+		all = append(all, samples...)
+		delete(t.unacked, seq)
+	}
+	return all
+}
+
+func (t *grpcTransport) Flush() error { return nil }
+
+func (t *grpcTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.Close()
+}
+
+// pushTransport is the subset of grpcTransport's behavior grpcSink depends
+// on, extracted so tests can exercise the sink's requeue logic against a
+// fake transport instead of a live gRPC connection.
+type pushTransport interface {
+	Write(samples []*cloud.Sample) error
+	// TakeUnacked removes and returns every batch the transport is still
+	// holding for in-memory replay, so a caller that can't rely on that
+	// replay surviving a crash (grpcSink, on a tracked failure) can spool
+	// it instead.
+	TakeUnacked() []*cloud.Sample
+	Flush() error
+	Close() error
+}
+
+// grpcSink adapts a pushTransport to the Sink interface so it can replace
+// the HTTP-based cloudSink when the gRPC transport is available and not
+// explicitly disabled. A batch that never made it onto the stream (e.g.
+// backpressure or a failed reconnect) is kept in pending and retried on
+// the next Write, rather than dropped. A batch the transport is already
+// replaying after a broken stream is additionally pulled out via
+// TakeUnacked and requeued here too, so it is spooled to disk like any
+// other failure instead of surviving only in the transport's in-memory
+// replay map, which a process crash or exit would lose.
+type grpcSink struct {
+	collector *Collector
+	transport pushTransport
+	pending   pendingBuffer
+}
+
+func (s *grpcSink) Write(samples []*cloud.Sample) error {
+	batch := s.pending.take(samples)
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := s.transport.Write(batch)
+	if err == nil {
+		return nil
+	}
+
+	var untracked *untrackedWriteError
+	if errors.As(err, &untracked) {
+		s.requeue(batch)
+		return err
+	}
+
+	// A tracked failure: the transport is holding batch (and possibly
+	// others) in its own unacked map for replay after its next reconnect.
+	// That's fine for an in-memory interruption, but not for a crash or
+	// exit in between - pull everything out of that map and requeue it
+	// the same way, so it ends up on disk if a spool is configured.
+	if unacked := s.transport.TakeUnacked(); len(unacked) > 0 {
+		s.requeue(unacked)
+	}
+	return err
+}
+
+// requeue preserves a batch the transport isn't already tracking for
+// replay - to the spool if one is configured, otherwise back onto this
+// sink's own pending buffer. This mirrors cloudSink.requeue so samples
+// survive a process exit/crash on the gRPC path too, not just HTTP.
+func (s *grpcSink) requeue(batch []*cloud.Sample) {
+	if s.collector.spool != nil {
+		if err := s.collector.spool.Write(batch); err == nil {
+			return
+		}
+		log.Warn("Failed to spool samples to disk, falling back to in-memory buffer")
+	}
+	s.pending.put(batch, s.collector.retry.MaxBufferedSamples)
+}
+
+func (s *grpcSink) receivePending(samples []*cloud.Sample) {
+	s.pending.put(samples, 0)
+}
+
+func (s *grpcSink) Flush() error { return s.transport.Flush() }
+func (s *grpcSink) Close() error { return s.transport.Close() }
+
+// pushSink picks the gRPC transport when available and not overridden by
+// config, falling back to the existing HTTP-based cloudSink otherwise.
+func pushSink(c *Collector, grpcAddr string, cfg TransportConfig) Sink {
+	if cfg.Transport != "http" && grpcAddr != "" && supportsGRPC(grpcAddr) {
+		log.Debug("Using gRPC transport for cloud metric push")
+		return &grpcSink{
+			collector: c,
+			transport: newGRPCTransport(grpcAddr, c.client.Token(), c.referenceID),
+		}
+	}
+	log.Debug("Using HTTP transport for cloud metric push")
+	return &cloudSink{collector: c}
+}
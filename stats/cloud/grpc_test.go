@@ -0,0 +1,168 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/loadimpact/k6/core/cloud"
+)
+
+// fakeTransport lets grpcSink's retry/requeue behavior be exercised without
+// a live gRPC connection. Each call to Write pops the next queued error (or
+// nil) and records the batch it was given. A tracked (non-untracked) error
+// is mirrored into unacked, just as grpcTransport.Write would record it in
+// its own unacked map, so TakeUnacked has something real to drain.
+type fakeTransport struct {
+	errs    []error
+	writes  [][]*cloud.Sample
+	unacked [][]*cloud.Sample
+}
+
+func (f *fakeTransport) Write(samples []*cloud.Sample) error {
+	f.writes = append(f.writes, samples)
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+
+	var untracked *untrackedWriteError
+	if err != nil && !errors.As(err, &untracked) {
+		f.unacked = append(f.unacked, samples)
+	}
+	return err
+}
+
+func (f *fakeTransport) TakeUnacked() []*cloud.Sample {
+	var all []*cloud.Sample
+	for _, batch := range f.unacked {
+		all = append(all, batch...)
+	}
+	f.unacked = nil
+	return all
+}
+
+func (f *fakeTransport) Flush() error { return nil }
+func (f *fakeTransport) Close() error { return nil }
+
+func TestGRPCSinkRequeuesUntrackedFailures(t *testing.T) {
+	ft := &fakeTransport{errs: []error{&untrackedWriteError{errors.New("backpressure")}}}
+	s := &grpcSink{collector: &Collector{retry: defaultRetryConfig()}, transport: ft}
+
+	batch := sampleBatch(1, 2)
+	if err := s.Write(batch); err == nil {
+		t.Fatal("expected the first Write to report the transport's error")
+	}
+
+	// The batch never made it into the transport's own bookkeeping, so the
+	// sink must retry it itself on the next Write.
+	if err := s.Write(sampleBatch(3)); err != nil {
+		t.Fatalf("expected the retried Write to succeed, got %v", err)
+	}
+	if len(ft.writes) != 2 {
+		t.Fatalf("expected 2 calls to transport.Write, got %d", len(ft.writes))
+	}
+	if len(ft.writes[1]) != 3 {
+		t.Fatalf("expected the requeued batch to be prepended to the new samples, got %d samples", len(ft.writes[1]))
+	}
+}
+
+func TestGRPCSinkPullsTrackedFailuresOutOfTransportToo(t *testing.T) {
+	ft := &fakeTransport{errs: []error{errors.New("stream send failed")}}
+	s := &grpcSink{collector: &Collector{retry: defaultRetryConfig()}, transport: ft}
+
+	batch := sampleBatch(1, 2)
+	if err := s.Write(batch); err == nil {
+		t.Fatal("expected the first Write to report the transport's error")
+	}
+
+	// The transport no longer owns replaying this batch on its own - the
+	// sink took it over TakeUnacked and requeued it onto its own pending
+	// buffer (no spool configured here), so it goes out again resent
+	// under a new sequence number, prepended to the next Write's samples.
+	if len(ft.unacked) != 0 {
+		t.Fatalf("expected the sink to have drained the transport's unacked map, got %d entries left", len(ft.unacked))
+	}
+	if err := s.Write(sampleBatch(3)); err != nil {
+		t.Fatalf("expected the next Write to succeed, got %v", err)
+	}
+	if len(ft.writes) != 2 {
+		t.Fatalf("expected 2 calls to transport.Write, got %d", len(ft.writes))
+	}
+	if len(ft.writes[1]) != 3 {
+		t.Fatalf("expected the requeued batch prepended to the new sample, got %d samples", len(ft.writes[1]))
+	}
+}
+
+func TestGRPCSinkSpoolsTrackedFailuresWhenConfigured(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := newSpool(SpoolConfig{SpoolDir: dir}, "ref1")
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+
+	ft := &fakeTransport{errs: []error{errors.New("stream send failed")}}
+	s := &grpcSink{collector: &Collector{retry: defaultRetryConfig(), spool: sp}, transport: ft}
+
+	batch := sampleBatch(1, 2)
+	if err := s.Write(batch); err == nil {
+		t.Fatal("expected Write to report the transport's error")
+	}
+	if sp.Empty() {
+		t.Fatal("expected the tracked failure's batch to have been spooled to disk too, not just left in the transport's in-memory replay map")
+	}
+	if len(ft.unacked) != 0 {
+		t.Fatal("expected the sink to have drained the transport's unacked map before spooling it")
+	}
+}
+
+func TestGRPCSinkSpoolsUntrackedFailuresWhenConfigured(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := newSpool(SpoolConfig{SpoolDir: dir}, "ref1")
+	if err != nil {
+		t.Fatalf("newSpool: %v", err)
+	}
+
+	ft := &fakeTransport{errs: []error{&untrackedWriteError{errors.New("backpressure")}}}
+	s := &grpcSink{collector: &Collector{retry: defaultRetryConfig(), spool: sp}, transport: ft}
+
+	batch := sampleBatch(1, 2)
+	if err := s.Write(batch); err == nil {
+		t.Fatal("expected Write to report the transport's error")
+	}
+	if sp.Empty() {
+		t.Fatal("expected the untracked batch to have been spooled to disk")
+	}
+}
+
+func TestGRPCSinkFlushAndCloseDelegateToTransport(t *testing.T) {
+	ft := &fakeTransport{}
+	s := &grpcSink{collector: &Collector{retry: defaultRetryConfig()}, transport: ft}
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
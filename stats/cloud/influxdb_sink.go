@@ -0,0 +1,113 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"fmt"
+
+	"github.com/influxdata/influxdb/client/v2"
+	"github.com/loadimpact/k6/core/cloud"
+)
+
+// influxDBSink writes samples to an InfluxDB instance using the line
+// protocol client, batching points the same way the rest of k6's InfluxDB
+// support does.
+type influxDBSink struct {
+	client    client.Client
+	database  string
+	precision string
+	retention string
+	batchSize int
+}
+
+func newInfluxDBSink(spec SinkSpec) (*influxDBSink, error) {
+	if spec.Addr == "" {
+		return nil, fmt.Errorf("influxdb sink requires an addr")
+	}
+	if spec.Database == "" {
+		return nil, fmt.Errorf("influxdb sink requires a db")
+	}
+
+	c, err := client.NewHTTPClient(client.HTTPConfig{Addr: spec.Addr})
+	if err != nil {
+		return nil, err
+	}
+
+	precision := spec.Precision
+	if precision == "" {
+		precision = "ns"
+	}
+	batchSize := spec.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	return &influxDBSink{
+		client:    c,
+		database:  spec.Database,
+		precision: precision,
+		retention: spec.RetentionPolicy,
+		batchSize: batchSize,
+	}, nil
+}
+
+func (s *influxDBSink) Write(samples []*cloud.Sample) error {
+	for start := 0; start < len(samples); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		if err := s.writeBatch(samples[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *influxDBSink) writeBatch(samples []*cloud.Sample) error {
+	bp, err := client.NewBatchPoints(client.BatchPointsConfig{
+		Database:        s.database,
+		Precision:       s.precision,
+		RetentionPolicy: s.retention,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, samp := range samples {
+		tags := make(map[string]string, len(samp.Data.Tags))
+		for k, v := range samp.Data.Tags {
+			tags[k] = v
+		}
+		fields := map[string]interface{}{"value": samp.Data.Value}
+
+		p, err := client.NewPoint(samp.Metric, tags, fields, samp.Data.Time)
+		if err != nil {
+			return err
+		}
+		bp.AddPoint(p)
+	}
+
+	return s.client.Write(bp)
+}
+
+func (s *influxDBSink) Flush() error { return nil }
+func (s *influxDBSink) Close() error { return s.client.Close() }
@@ -0,0 +1,141 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/loadimpact/k6/core/cloud"
+	"github.com/loadimpact/k6/stats"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// prometheusSink exposes collected samples on a /metrics endpoint, deriving
+// the metric kind (counter, gauge, histogram) from stats.Metric.Type.
+type prometheusSink struct {
+	registry *prometheus.Registry
+	server   *http.Server
+
+	mu         sync.Mutex
+	counters   map[string]prometheus.Counter
+	gauges     map[string]prometheus.Gauge
+	histograms map[string]prometheus.Histogram
+}
+
+func newPrometheusSink(spec SinkSpec) (*prometheusSink, error) {
+	addr := spec.ListenAddress
+	if addr == "" {
+		addr = ":5656"
+	}
+
+	registry := prometheus.NewRegistry()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	sink := &prometheusSink{
+		registry:   registry,
+		server:     &http.Server{Addr: addr, Handler: mux},
+		counters:   make(map[string]prometheus.Counter),
+		gauges:     make(map[string]prometheus.Gauge),
+		histograms: make(map[string]prometheus.Histogram),
+	}
+
+	go func() {
+		if err := sink.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithFields(log.Fields{"error": err}).Error("Prometheus sink HTTP server failed")
+		}
+	}()
+
+	return sink, nil
+}
+
+func (s *prometheusSink) Write(samples []*cloud.Sample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, samp := range samples {
+		switch samp.Data.Type {
+		case stats.Counter:
+			s.counterFor(samp.Metric).Add(samp.Data.Value)
+		case stats.Gauge:
+			s.gaugeFor(samp.Metric).Set(samp.Data.Value)
+		default:
+			s.histogramFor(samp.Metric).Observe(samp.Data.Value)
+		}
+	}
+	return nil
+}
+
+func (s *prometheusSink) counterFor(name string) prometheus.Counter {
+	c, ok := s.counters[name]
+	if !ok {
+		c = prometheus.NewCounter(prometheus.CounterOpts{Name: metricName(name), Help: name})
+		s.registry.MustRegister(c)
+		s.counters[name] = c
+	}
+	return c
+}
+
+func (s *prometheusSink) gaugeFor(name string) prometheus.Gauge {
+	g, ok := s.gauges[name]
+	if !ok {
+		g = prometheus.NewGauge(prometheus.GaugeOpts{Name: metricName(name), Help: name})
+		s.registry.MustRegister(g)
+		s.gauges[name] = g
+	}
+	return g
+}
+
+func (s *prometheusSink) histogramFor(name string) prometheus.Histogram {
+	h, ok := s.histograms[name]
+	if !ok {
+		h = prometheus.NewHistogram(prometheus.HistogramOpts{Name: metricName(name), Help: name})
+		s.registry.MustRegister(h)
+		s.histograms[name] = h
+	}
+	return h
+}
+
+// metricName sanitizes a k6 metric name into a valid Prometheus metric
+// name and adds a "k6_" prefix (e.g. "http_req_duration" becomes
+// "k6_http_req_duration", and so does "http.req.duration", which the
+// registry would reject unsanitized).
+func metricName(name string) string {
+	out := make([]rune, len(name))
+	for i, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			out[i] = r
+		} else {
+			out[i] = '_'
+		}
+	}
+	return "k6_" + string(out)
+}
+
+func (s *prometheusSink) Flush() error { return nil }
+
+func (s *prometheusSink) Close() error {
+	return s.server.Shutdown(context.Background())
+}
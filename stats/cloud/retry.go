@@ -0,0 +1,159 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// RetryConfig controls how pushes to the cloud API are retried on transient
+// failures. It is decoded from the same "loadimpact" external options block
+// as the rest of the cloud collector's settings.
+type RetryConfig struct {
+	Attempts           int           `mapstructure:"retry_attempts"`
+	BaseDelay          time.Duration `mapstructure:"retry_base_delay"`
+	MaxDelay           time.Duration `mapstructure:"retry_max_delay"`
+	CallTimeout        time.Duration `mapstructure:"retry_timeout"`
+	MaxBufferedSamples int           `mapstructure:"max_buffered_samples"`
+}
+
+// defaultRetryConfig returns the settings used when the "loadimpact" options
+// block does not override them.
+func defaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		Attempts:           5,
+		BaseDelay:          500 * time.Millisecond,
+		MaxDelay:           30 * time.Second,
+		CallTimeout:        10 * time.Second,
+		MaxBufferedSamples: 100000,
+	}
+}
+
+// MultiError aggregates the errors from every failed attempt of a retried
+// call, so operators can see the full failure chain instead of just the
+// last one.
+type MultiError struct {
+	Errors []error
+}
+
+func (m *MultiError) Error() string {
+	if len(m.Errors) == 1 {
+		return m.Errors[0].Error()
+	}
+	msgs := make([]string, len(m.Errors))
+	for i, err := range m.Errors {
+		msgs[i] = fmt.Sprintf("attempt %d: %s", i+1, err.Error())
+	}
+	return fmt.Sprintf("%d attempts failed: %s", len(m.Errors), strings.Join(msgs, "; "))
+}
+
+func (m *MultiError) append(err error) {
+	m.Errors = append(m.Errors, err)
+}
+
+// statusCoder is implemented by cloud API errors that carry the HTTP status
+// code of the failed request. None of the errors returned by the current
+// core/cloud.Client methods implement it, so in practice every error falls
+// through to the "retry" branch below; the interface exists so a future
+// status-carrying client error starts getting 4xx/5xx treatment for free,
+// without another call site change.
+type statusCoder interface {
+	StatusCode() int
+}
+
+// isRetryableError reports whether err is worth retrying. If err carries an
+// HTTP status code (see statusCoder), only 5xx responses (and a zero status)
+// are retried and every other status, including 4xx (bad request, auth,
+// etc.), is treated as terminal; errors that
+// don't carry a status - which, today, is all of them - are always retried,
+// since we can't tell a permanent failure from a transient one without it.
+func isRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if sc, ok := err.(statusCoder); ok {
+		return sc.StatusCode() >= 500 || sc.StatusCode() == 0
+	}
+	return true
+}
+
+// withRetry calls fn until it succeeds, fn's error is terminal, or attempts
+// are exhausted. Delays between attempts grow exponentially from baseDelay
+// up to maxDelay, with jitter added to avoid retry storms against the cloud
+// API. If every attempt fails, the returned error is a *MultiError holding
+// one entry per attempt.
+func withRetry(attempts int, baseDelay, maxDelay time.Duration, fn func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	merr := &MultiError{}
+	delay := baseDelay
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		merr.append(err)
+
+		if !isRetryableError(err) {
+			break
+		}
+		if attempt == attempts {
+			break
+		}
+
+		jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		time.Sleep(delay + jitter)
+
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+
+	return merr
+}
+
+// withTimeout runs fn and returns its error, or a timeout error if it
+// doesn't complete within d. d <= 0 disables the timeout. The cloud.Client
+// methods this package calls don't accept a context, so racing fn on its
+// own goroutine against a timer is the only way to bound an individual
+// call's duration - a call that times out keeps running in the background,
+// since there's nothing to cancel it with.
+func withTimeout(d time.Duration, fn func() error) error {
+	if d <= 0 {
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(d):
+		return fmt.Errorf("call timed out after %s", d)
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string   { return "status error" }
+func (e *statusError) StatusCode() int { return e.code }
+
+func TestWithRetrySucceedsImmediately(t *testing.T) {
+	calls := 0
+	err := withRetry(5, time.Millisecond, 10*time.Millisecond, func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+}
+
+func TestWithRetrySucceedsAfterTransientFailures(t *testing.T) {
+	calls := 0
+	err := withRetry(5, time.Millisecond, 10*time.Millisecond, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("connection reset")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestWithRetryStopsOnTerminalError(t *testing.T) {
+	calls := 0
+	err := withRetry(5, time.Millisecond, 10*time.Millisecond, func() error {
+		calls++
+		return &statusError{code: 400}
+	})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if calls != 1 {
+		t.Fatalf("expected terminal error to stop after 1 call, got %d calls", calls)
+	}
+}
+
+func TestWithRetryExhaustsAttemptsAndAggregatesErrors(t *testing.T) {
+	calls := 0
+	err := withRetry(3, time.Millisecond, 10*time.Millisecond, func() error {
+		calls++
+		return errors.New("boom")
+	})
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+	merr, ok := err.(*MultiError)
+	if !ok {
+		t.Fatalf("expected *MultiError, got %T", err)
+	}
+	if len(merr.Errors) != 3 {
+		t.Fatalf("expected 3 aggregated errors, got %d", len(merr.Errors))
+	}
+}
+
+func TestWithTimeoutReturnsFnError(t *testing.T) {
+	want := errors.New("boom")
+	err := withTimeout(10*time.Millisecond, func() error {
+		return want
+	})
+	if err != want {
+		t.Fatalf("expected %v, got %v", want, err)
+	}
+}
+
+func TestWithTimeoutExpires(t *testing.T) {
+	err := withTimeout(10*time.Millisecond, func() error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	})
+	if err == nil {
+		t.Fatal("expected a timeout error")
+	}
+}
+
+func TestWithTimeoutDisabledWhenNonPositive(t *testing.T) {
+	calls := 0
+	err := withTimeout(0, func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected fn to run once, got %d calls", calls)
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil error", nil, false},
+		{"plain network error", errors.New("dial tcp: connection refused"), true},
+		{"5xx status", &statusError{code: 503}, true},
+		{"4xx status", &statusError{code: 404}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isRetryableError(c.err); got != c.want {
+				t.Errorf("isRetryableError(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
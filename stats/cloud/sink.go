@@ -0,0 +1,195 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"sync"
+
+	"github.com/loadimpact/k6/core/cloud"
+	log "github.com/sirupsen/logrus"
+)
+
+// Sink is a destination samples can be streamed to in addition to (or
+// instead of) the Load Impact cloud. It lets the collector fan metrics out
+// to local backends such as InfluxDB or Prometheus alongside the cloud
+// upload that drives the hosted UI.
+type Sink interface {
+	// Write hands the sink a batch of samples collected since the last
+	// call. Implementations should not retain the slice.
+	Write(samples []*cloud.Sample) error
+	// Flush asks the sink to push out anything it may be buffering
+	// internally.
+	Flush() error
+	// Close releases any resources (connections, listeners) held by the
+	// sink. It is called once, when the test run ends.
+	Close() error
+}
+
+// SinksConfig is the "sinks" sub-section of the "loadimpact" external
+// options block, listing the additional backends samples should be
+// streamed to.
+type SinksConfig struct {
+	Sinks []SinkSpec `mapstructure:"sinks"`
+}
+
+// SinkSpec configures a single sink. Only the fields relevant to Type are
+// read; the rest are ignored.
+type SinkSpec struct {
+	Type string `mapstructure:"type"`
+
+	// InfluxDB
+	Addr            string `mapstructure:"addr"`
+	Database        string `mapstructure:"db"`
+	Precision       string `mapstructure:"precision"`
+	BatchSize       int    `mapstructure:"batch_size"`
+	RetentionPolicy string `mapstructure:"retention_policy"`
+
+	// Prometheus
+	ListenAddress string `mapstructure:"listen_address"`
+}
+
+// buildSinks constructs one Sink per entry in cfg.Sinks. A sink that fails
+// to construct (e.g. a malformed InfluxDB address) is skipped with a
+// warning rather than aborting the whole collector, so a broken secondary
+// backend never prevents the cloud sink from working.
+func buildSinks(cfg SinksConfig) []Sink {
+	sinks := make([]Sink, 0, len(cfg.Sinks))
+	for _, spec := range cfg.Sinks {
+		switch spec.Type {
+		case "", "cloud":
+			// The cloud sink is always added separately by the collector;
+			// an explicit "cloud" entry is accepted but otherwise a no-op.
+			continue
+		case "influxdb":
+			sink, err := newInfluxDBSink(spec)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err}).Warn("Failed to set up InfluxDB sink")
+				continue
+			}
+			sinks = append(sinks, sink)
+		case "prometheus":
+			sink, err := newPrometheusSink(spec)
+			if err != nil {
+				log.WithFields(log.Fields{"error": err}).Warn("Failed to set up Prometheus sink")
+				continue
+			}
+			sinks = append(sinks, sink)
+		default:
+			log.WithFields(log.Fields{"type": spec.Type}).Warn("Unknown loadimpact sink type, ignoring")
+		}
+	}
+	return sinks
+}
+
+// pendingBuffer holds samples a sink failed to deliver, so they can be
+// retried on that sink's next Write call instead of being redelivered to
+// every sink in the fan-out (which would double-count them on any sink
+// that already wrote them successfully).
+type pendingBuffer struct {
+	mu      sync.Mutex
+	samples []*cloud.Sample
+}
+
+// take prepends any previously failed samples onto extra and clears the
+// buffer; the combined batch is what the caller should attempt to send.
+func (p *pendingBuffer) take(extra []*cloud.Sample) []*cloud.Sample {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.samples) == 0 {
+		return extra
+	}
+	batch := append(p.samples, extra...)
+	p.samples = nil
+	return batch
+}
+
+// put stores a batch that failed to send for the next take call, bounded
+// by maxBuffered (0 means unbounded).
+func (p *pendingBuffer) put(samples []*cloud.Sample, maxBuffered int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.samples = append(p.samples, samples...)
+	if maxBuffered > 0 && len(p.samples) > maxBuffered {
+		dropped := len(p.samples) - maxBuffered
+		log.WithFields(log.Fields{
+			"dropped": dropped,
+		}).Warn("Sink retry buffer exceeded max in-memory sample count, dropping oldest samples")
+		p.samples = p.samples[dropped:]
+	}
+}
+
+// pendingReceiver is implemented by sinks that keep their own retry buffer,
+// so samples drained from the on-disk spool can be handed back to the sink
+// that originally failed to send them instead of re-entering the shared
+// fan-out buffer.
+type pendingReceiver interface {
+	receivePending(samples []*cloud.Sample)
+}
+
+// cloudSink is the Sink wrapper around the Load Impact cloud API itself,
+// preserving the collector's original single-destination behavior.
+type cloudSink struct {
+	collector *Collector
+	pending   pendingBuffer
+}
+
+func (s *cloudSink) Write(samples []*cloud.Sample) error {
+	if s.collector.referenceID == "" {
+		return nil
+	}
+
+	batch := s.pending.take(samples)
+	if len(batch) == 0 {
+		return nil
+	}
+
+	err := withRetry(s.collector.retry.Attempts, s.collector.retry.BaseDelay, s.collector.retry.MaxDelay, func() error {
+		return withTimeout(s.collector.retry.CallTimeout, func() error {
+			return s.collector.client.PushMetric(s.collector.referenceID, batch)
+		})
+	})
+	if err != nil {
+		s.requeue(batch)
+	}
+	return err
+}
+
+// requeue preserves a batch this sink alone failed to send - to the spool
+// if one is configured, otherwise back onto this sink's own pending
+// buffer. Either way, only this sink will see the batch again.
+func (s *cloudSink) requeue(batch []*cloud.Sample) {
+	if s.collector.spool != nil {
+		if err := s.collector.spool.Write(batch); err == nil {
+			return
+		}
+		log.Warn("Failed to spool samples to disk, falling back to in-memory buffer")
+	}
+	s.pending.put(batch, s.collector.retry.MaxBufferedSamples)
+}
+
+func (s *cloudSink) receivePending(samples []*cloud.Sample) {
+	s.pending.put(samples, 0)
+}
+
+func (s *cloudSink) Flush() error { return nil }
+func (s *cloudSink) Close() error { return nil }
@@ -0,0 +1,102 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import "testing"
+
+func TestBuildSinksSkipsCloudAndUnknownTypes(t *testing.T) {
+	sinks := buildSinks(SinksConfig{Sinks: []SinkSpec{
+		{Type: ""},
+		{Type: "cloud"},
+		{Type: "bogus"},
+	}})
+	if len(sinks) != 0 {
+		t.Fatalf("expected no sinks, got %d", len(sinks))
+	}
+}
+
+func TestBuildSinksSkipsInvalidInfluxDBSpec(t *testing.T) {
+	sinks := buildSinks(SinksConfig{Sinks: []SinkSpec{
+		{Type: "influxdb"},
+	}})
+	if len(sinks) != 0 {
+		t.Fatalf("expected a malformed influxdb spec to be skipped, got %d sinks", len(sinks))
+	}
+}
+
+func TestMetricNameSanitizesAndPrefixes(t *testing.T) {
+	cases := map[string]string{
+		"http_req_duration": "k6_http_req_duration",
+		"http.req.duration": "k6_http_req_duration",
+		"my-metric!":        "k6_my_metric_",
+	}
+	for in, want := range cases {
+		if got := metricName(in); got != want {
+			t.Errorf("metricName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPendingBufferTakeReturnsExtraWhenEmpty(t *testing.T) {
+	var p pendingBuffer
+	extra := sampleBatch(1, 2)
+	got := p.take(extra)
+	if len(got) != 2 {
+		t.Fatalf("expected take to pass extra through unchanged, got %d samples", len(got))
+	}
+}
+
+func TestPendingBufferPutThenTakePrependsStoredSamples(t *testing.T) {
+	var p pendingBuffer
+	p.put(sampleBatch(1), 0)
+
+	got := p.take(sampleBatch(2))
+	if len(got) != 2 || got[0].Data.Value != 1 || got[1].Data.Value != 2 {
+		t.Fatalf("expected stored sample to be prepended to the new batch, got %v", got)
+	}
+
+	// take drains the buffer, so a second call only sees new samples.
+	got = p.take(sampleBatch(3))
+	if len(got) != 1 || got[0].Data.Value != 3 {
+		t.Fatalf("expected buffer to be empty after take, got %v", got)
+	}
+}
+
+func TestPendingBufferPutTwiceKeepsOldestFirst(t *testing.T) {
+	var p pendingBuffer
+	p.put(sampleBatch(1), 0)
+	p.put(sampleBatch(2), 0)
+
+	got := p.take(nil)
+	if len(got) != 2 || got[0].Data.Value != 1 || got[1].Data.Value != 2 {
+		t.Fatalf("expected the first put's batch to stay ahead of the second, got %v", got)
+	}
+}
+
+func TestPendingBufferPutDropsOldestOverMaxBuffered(t *testing.T) {
+	var p pendingBuffer
+	p.put(sampleBatch(1, 2, 3), 2)
+
+	got := p.take(nil)
+	if len(got) != 2 || got[0].Data.Value != 2 || got[1].Data.Value != 3 {
+		t.Fatalf("expected the oldest sample to be dropped, got %v", got)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ *
+ * k6 - a next-generation load testing tool
+ * Copyright (C) 2017 Load Impact
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package cloud
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loadimpact/k6/core/cloud"
+	log "github.com/sirupsen/logrus"
+)
+
+// SpoolConfig controls the on-disk spool used to hold samples that
+// couldn't be pushed to the cloud, so they survive process restarts and a
+// flaky network instead of being dropped from memory.
+type SpoolConfig struct {
+	SpoolDir          string        `mapstructure:"spool_dir"`
+	SpoolMaxBytes     int64         `mapstructure:"spool_max_bytes"`
+	SpoolDrainTimeout time.Duration `mapstructure:"spool_drain_timeout"`
+}
+
+func defaultSpoolConfig() SpoolConfig {
+	return SpoolConfig{
+		SpoolMaxBytes:     50 * 1024 * 1024,
+		SpoolDrainTimeout: 30 * time.Second,
+	}
+}
+
+// spool persists batches of samples as gzip-compressed ndjson files under
+// <dir>/NNNN.ndjson.gz, oldest sequence number first. The collector writes
+// to it when a push fails (or, for the overflow spool, when the in-memory
+// buffer crosses its watermark) and drains it at the start of every push.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	nextSeq int
+}
+
+func newSpool(cfg SpoolConfig, referenceID string) (*spool, error) {
+	dir := cfg.SpoolDir
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".k6", "spool")
+	}
+	dir = filepath.Join(dir, referenceID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &spool{dir: dir, maxBytes: cfg.SpoolMaxBytes}
+	for _, f := range s.files() {
+		if info, err := os.Stat(filepath.Join(dir, f)); err == nil {
+			s.size += info.Size()
+		}
+		if seq, err := strconv.Atoi(strings.TrimSuffix(f, ".ndjson.gz")); err == nil && seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	return s, nil
+}
+
+// files returns the spool's ndjson.gz files sorted oldest-first.
+func (s *spool) files() []string {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".gz" {
+			names = append(names, e.Name())
+		}
+	}
+	// Sort by sequence number rather than by name, so "10000" still comes
+	// after "9999" once sequence numbers outgrow the zero-padded width.
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimSuffix(names[i], ".ndjson.gz"))
+		b, _ := strconv.Atoi(strings.TrimSuffix(names[j], ".ndjson.gz"))
+		return a < b
+	})
+	return names
+}
+
+// Write appends a batch to a new file at the end of the spool, evicting
+// the oldest files first if that pushes the spool over maxBytes.
+func (s *spool) Write(samples []*cloud.Sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := filepath.Join(s.dir, fmt.Sprintf("%04d.ndjson.gz", s.nextSeq))
+	s.nextSeq++
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	for _, samp := range samples {
+		if err := enc.Encode(samp); err != nil {
+			_ = gz.Close()
+			return err
+		}
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(name); err == nil {
+		s.size += info.Size()
+	}
+	s.evictOldestLocked()
+	return nil
+}
+
+// evictOldestLocked drops the oldest spool files until the spool is back
+// under maxBytes. The newest file (the batch just written) is never
+// evicted, even if it alone exceeds maxBytes. Callers must hold s.mu.
+func (s *spool) evictOldestLocked() {
+	if s.maxBytes <= 0 {
+		return
+	}
+	files := s.files()
+	for i := 0; s.size > s.maxBytes && i < len(files)-1; i++ {
+		path := filepath.Join(s.dir, files[i])
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			continue
+		}
+		s.size -= info.Size()
+		log.WithFields(log.Fields{"file": files[i]}).Warn("Spool exceeded max size, dropped oldest spooled batch")
+	}
+}
+
+// Drain reads and removes the oldest spooled file, returning its samples.
+// It returns a nil slice once the spool is empty.
+func (s *spool) Drain() ([]*cloud.Sample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := s.files()
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	path := filepath.Join(s.dir, files[0])
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var samples []*cloud.Sample
+	dec := json.NewDecoder(gz)
+	for {
+		var samp cloud.Sample
+		if err := dec.Decode(&samp); err != nil {
+			break
+		}
+		samples = append(samples, &samp)
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		s.size -= info.Size()
+	}
+	if err := os.Remove(path); err != nil {
+		return samples, err
+	}
+	return samples, nil
+}
+
+// Empty reports whether the spool currently holds no files.
+func (s *spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files()) == 0
+}